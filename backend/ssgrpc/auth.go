@@ -0,0 +1,162 @@
+package ssgrpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/token"
+)
+
+// Claims is the verified identity of a peer that authenticated an
+// inbound RPC.
+type Claims struct {
+	Subject string
+	Expiry  int64
+}
+
+// Authenticator signs outbound peer credentials and verifies inbound
+// ones. It replaces the old hard-coded HMAC-SHA256/sharedKey pairing so
+// operators can plug in their own KMS or an OIDC issuer instead.
+type Authenticator interface {
+	// SignOutbound returns the bearer token this node presents to peers.
+	SignOutbound(ctx context.Context) (string, error)
+	// VerifyInbound checks a token presented by a peer and returns its
+	// claims.
+	VerifyInbound(tokenStr string) (Claims, error)
+}
+
+// KeySet holds the HMAC keys an HMACAuthenticator will sign or verify
+// with, each identified by a "kid". Adding a new key lets peers start
+// verifying tokens signed with it immediately; removing one retires it.
+// Rotation is therefore: add the new key everywhere, wait for it to
+// propagate, switch SigningKid, then remove the old key everywhere
+// without a synchronized restart.
+type KeySet struct {
+	l          sync.RWMutex
+	keys       map[string][]byte
+	signingKid string
+}
+
+// NewKeySet builds a KeySet with a single starting key, used both for
+// signing and verification.
+func NewKeySet(kid string, key []byte) *KeySet {
+	return &KeySet{
+		keys:       map[string][]byte{kid: key},
+		signingKid: kid,
+	}
+}
+
+// AddKey adds key under kid to the set of keys accepted for
+// verification. It does not change which key is used for signing.
+func (ks *KeySet) AddKey(kid string, key []byte) {
+	ks.l.Lock()
+	defer ks.l.Unlock()
+
+	ks.keys[kid] = key
+}
+
+// RemoveKey stops a key from being accepted for verification. Callers
+// should only remove a key once they're confident every peer has picked
+// up a replacement via AddKey.
+func (ks *KeySet) RemoveKey(kid string) {
+	ks.l.Lock()
+	defer ks.l.Unlock()
+
+	delete(ks.keys, kid)
+}
+
+// SetSigningKid switches which key new outbound tokens are signed with.
+// The key must already have been added with AddKey.
+func (ks *KeySet) SetSigningKid(kid string) error {
+	ks.l.Lock()
+	defer ks.l.Unlock()
+
+	if _, ok := ks.keys[kid]; !ok {
+		return fmt.Errorf("ssgrpc: unknown kid %q", kid)
+	}
+
+	ks.signingKid = kid
+	return nil
+}
+
+func (ks *KeySet) signingKey() (kid string, key []byte) {
+	ks.l.RLock()
+	defer ks.l.RUnlock()
+
+	return ks.signingKid, ks.keys[ks.signingKid]
+}
+
+func (ks *KeySet) keyFor(kid string) ([]byte, bool) {
+	ks.l.RLock()
+	defer ks.l.RUnlock()
+
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// hmacAuthenticator is the default Authenticator: HMAC-SHA256 tokens
+// signed and verified against a rotating KeySet, refreshed shortly
+// before they expire.
+type hmacAuthenticator struct {
+	subject string
+	ttl     time.Duration
+	keys    *KeySet
+
+	l           sync.RWMutex
+	tokenStr    string
+	tokenExpire int64
+}
+
+// NewHMACAuthenticator returns an Authenticator that signs tokens for
+// subject with keys, refreshing the outbound token ttl before it
+// expires. Inbound tokens are verified against whichever key their "kid"
+// header names.
+func NewHMACAuthenticator(subject string, ttl time.Duration, keys *KeySet) Authenticator {
+	return &hmacAuthenticator{subject: subject, ttl: ttl, keys: keys}
+}
+
+func (h *hmacAuthenticator) SignOutbound(ctx context.Context) (string, error) {
+	h.l.RLock()
+	tok := h.tokenStr
+	exp := h.tokenExpire
+	h.l.RUnlock()
+
+	if exp-300 > time.Now().Unix() {
+		return tok, nil
+	}
+
+	kid, key := h.keys.signingKey()
+
+	u, signed, err := token.GenUserTokenWithKid(h.subject, h.ttl, key, kid)
+	if err != nil {
+		return "", err
+	}
+
+	h.l.Lock()
+	h.tokenStr = signed
+	h.tokenExpire = u.EXP
+	h.l.Unlock()
+
+	return signed, nil
+}
+
+func (h *hmacAuthenticator) VerifyInbound(tokenStr string) (Claims, error) {
+	kid, err := token.Kid(tokenStr)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	key, ok := h.keys.keyFor(kid)
+	if !ok {
+		return Claims{}, fmt.Errorf("ssgrpc: unknown signing key %q", kid)
+	}
+
+	u, err := token.VerifyUserToken(tokenStr, key)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{Subject: u.Sub, Expiry: u.EXP}, nil
+}
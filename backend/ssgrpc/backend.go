@@ -0,0 +1,407 @@
+package ssgrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raz-varren/log"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/discovery"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// Option configures optional Backend behavior not covered by NewBackend's
+// required arguments.
+type Option func(*Backend)
+
+// WithAuthenticator overrides the default HMAC-SHA256/sharedKey
+// Authenticator, letting operators plug in a rotating KeySet (see
+// NewHMACAuthenticator) or delegate peer identity to an OIDC issuer (see
+// NewJWKSAuthenticator).
+func WithAuthenticator(auth Authenticator) Option {
+	return func(b *Backend) {
+		b.auth = auth
+	}
+}
+
+// WithQueueOptions configures the durable, ack'd outbound queue kept for
+// each peer (WAL location, max size, and what to do once it's full). The
+// zero value is an unbounded in-memory-only queue (no WAL dir).
+func WithQueueOptions(opts QueueOptions) Option {
+	return func(b *Backend) {
+		b.queueOpts = opts
+	}
+}
+
+// Backend is an ss.MultihomeBackend that propagates Roomcast, Broadcast,
+// and Socketcast calls to a mesh of peer nodes over gRPC. Use NewBackend,
+// NewInsecureBackend, or one of the *WithSource variants to construct
+// one; the zero value isn't ready to use.
+type Backend struct {
+	hostPort string
+
+	server    *grpc.Server
+	listener  net.Listener
+	health    *healthServer
+	propagate *propagateServer
+
+	peers     *peerManager
+	auth      Authenticator
+	queueOpts QueueOptions
+	nodeID    string
+
+	serverCreds *reloadableCreds
+	clientCreds *reloadableCreds
+
+	l             sync.Mutex
+	stopDiscovery context.CancelFunc
+	discoverySrc  discovery.PeerSource
+}
+
+func newBackend(hostPort string, sharedKey []byte, staticPeers []string, serverCreds, clientCreds *reloadableCreds, opts ...Option) *Backend {
+	b := &Backend{
+		hostPort:    hostPort,
+		health:      newHealthServer(),
+		nodeID:      hostPort,
+		serverCreds: serverCreds,
+		clientCreds: clientCreds,
+	}
+
+	if sharedKey != nil {
+		b.auth = NewHMACAuthenticator("ssgrpcClient", time.Hour, NewKeySet("default", sharedKey))
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.peers = newPeerManager(func(peer string) (*propagateClient, error) {
+		_, dialHostPort := splitPeerCN(peer)
+
+		var dialOpts []grpc.DialOption
+		if clientCreds != nil {
+			dialOpts = append(dialOpts, mutualTLSDialOption(clientCreds))
+		} else {
+			dialOpts = append(dialOpts, grpc.WithInsecure())
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(`{"healthCheckConfig": {"serviceName": "ss.Server"}}`))
+		if b.auth != nil {
+			dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&perRPCCreds{auth: b.auth, secure: clientCreds != nil}))
+		}
+
+		conn, err := grpc.Dial(dialHostPort, dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &propagateClient{
+			conn:   conn,
+			client: transport.NewPropagateClient(conn),
+		}, nil
+	}, func(peer string) (*outboundQueue, error) {
+		return newOutboundQueue(peer, b.nodeID, b.queueOpts)
+	}, runSender)
+
+	var serverOpts []grpc.ServerOption
+	if serverCreds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(serverCreds))
+	}
+	if b.auth != nil {
+		serverOpts = append(serverOpts,
+			grpc.UnaryInterceptor(b.authUnaryInterceptor),
+			grpc.StreamInterceptor(b.authStreamInterceptor))
+	}
+
+	b.server = grpc.NewServer(serverOpts...)
+	healthpb.RegisterHealthServer(b.server, b.health)
+
+	statePath := ""
+	if b.queueOpts.Dir != "" {
+		statePath = filepath.Join(b.queueOpts.Dir, "recv_highseq.json")
+	}
+	propagate, err := newPropagateServer(statePath)
+	if err != nil {
+		log.Err.Println("ssgrpc: load propagate dedup state error, starting empty:", err)
+		propagate, _ = newPropagateServer("")
+	}
+	b.propagate = propagate
+	transport.RegisterPropagateServer(b.server, b.propagate)
+
+	if lis, err := net.Listen("tcp", hostPort); err != nil {
+		log.Err.Println("ssgrpc: listen on", hostPort, "error:", err)
+	} else {
+		b.listener = lis
+		go func() {
+			if err := b.server.Serve(lis); err != nil {
+				log.Err.Println("ssgrpc: serve error:", err)
+			}
+		}()
+	}
+
+	if len(staticPeers) > 0 {
+		b.startDiscovery(discovery.Static(staticPeers))
+	}
+
+	return b
+}
+
+// authUnaryInterceptor verifies the bearer token a peer presents before
+// letting a unary RPC (e.g. Health/Check) through.
+func (b *Backend) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := b.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStreamInterceptor is authUnaryInterceptor for streaming RPCs (e.g.
+// Health/Watch, the ack'd Propagate stream).
+func (b *Backend) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := b.authenticate(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (b *Backend) authenticate(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return fmt.Errorf("ssgrpc: no peer metadata")
+	}
+
+	auth := md.Get("authorization")
+	if len(auth) == 0 {
+		return fmt.Errorf("ssgrpc: missing authorization metadata")
+	}
+
+	tokenStr := strings.TrimPrefix(auth[0], "Bearer ")
+
+	_, err := b.auth.VerifyInbound(tokenStr)
+	return err
+}
+
+// Roomcast propagates a roomcast to every connected peer, which then
+// delivers it to sockets joined to room on that peer. The message is
+// durably queued per peer and retried until acked, so it isn't lost to a
+// peer whose stream is momentarily down.
+func (b *Backend) Roomcast(room string, msg []byte) error {
+	return b.fanout(transport.PropagateMsg_ROOMCAST, room, "", msg)
+}
+
+// Broadcast propagates a message to every socket connected to every
+// peer, with the same durable, at-least-once delivery as Roomcast.
+func (b *Backend) Broadcast(msg []byte) error {
+	return b.fanout(transport.PropagateMsg_BROADCAST, "", "", msg)
+}
+
+// Socketcast propagates a message to a single socket, wherever in the
+// mesh it's connected, with the same durable, at-least-once delivery as
+// Roomcast.
+func (b *Backend) Socketcast(socketID string, msg []byte) error {
+	return b.fanout(transport.PropagateMsg_SOCKETCAST, "", socketID, msg)
+}
+
+// fanout enqueues msg onto every connected peer's durable outbound
+// queue, including peers currently suspended (NOT_SERVING, or too many
+// consecutive failures) — their sender goroutine (see runSender) just
+// isn't making progress right now, it hasn't gone away, so the message
+// must stay queued for it the same as for a healthy peer. It's the
+// queue's own MaxSize/DropPolicy, not peer health, that bounds how much
+// backlog a single dead peer can accumulate.
+func (b *Backend) fanout(kind transport.PropagateMsg_Kind, room, socketID string, data []byte) error {
+	for _, pc := range b.peers.clients() {
+		msg := buildPropagateMsg(b.nodeID, pc.queue.streamID, kind, room, socketID, data)
+		pc.queue.enqueue(msg)
+	}
+
+	return nil
+}
+
+// SetDeliverer wires d to receive every propagate message this node
+// accepts from a peer, deduped and in order. ss.Server should call this
+// once, right after SetMultihomeBackend; until it does, accepted
+// messages are logged and dropped instead of reaching local sockets.
+func (b *Backend) SetDeliverer(d Deliverer) {
+	b.propagate.setDeliverer(d)
+}
+
+// Flush waits for every peer's outbound queue to drain — i.e. every
+// message enqueued so far has been acked — or for ctx to be canceled.
+// Call it during graceful shutdown so in-flight Roomcast/Broadcast/
+// Socketcast calls aren't silently dropped.
+func (b *Backend) Flush(ctx context.Context) error {
+	return b.peers.flush(ctx)
+}
+
+// PeerStats returns a snapshot of connection health for every currently
+// connected peer, including in-flight propagate calls, EWMA RTT, and
+// consecutive failure counts. It's useful for exposing peer mesh health
+// on an admin/metrics endpoint.
+func (b *Backend) PeerStats() []PeerStats {
+	byPeer := b.peers.clientsByPeer()
+	stats := make([]PeerStats, 0, len(byPeer))
+
+	for peer, pc := range byPeer {
+		healthy, suspended, inFlight, rtt, failures := pc.health.snapshot()
+		stats = append(stats, PeerStats{
+			Peer:                peer,
+			Healthy:             healthy,
+			Suspended:           suspended,
+			InFlight:            inFlight,
+			RTT:                 rtt,
+			ConsecutiveFailures: failures,
+		})
+	}
+
+	return stats
+}
+
+// startDiscovery wires src into the backend's peerManager so that
+// propagateClient connections are created and torn down as src reports
+// peers joining and leaving. It runs for the lifetime of the backend.
+func (b *Backend) startDiscovery(src discovery.PeerSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b.l.Lock()
+	b.stopDiscovery = cancel
+	b.discoverySrc = src
+	b.l.Unlock()
+
+	go b.peers.run(ctx, src)
+}
+
+// Close reports NOT_SERVING to peers watching this node's health, stops
+// peer discovery, and closes all peer connections and the local gRPC
+// server.
+func (b *Backend) Close() error {
+	b.health.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	b.l.Lock()
+	if b.stopDiscovery != nil {
+		b.stopDiscovery()
+	}
+	src := b.discoverySrc
+	b.l.Unlock()
+
+	// Some PeerSource implementations (e.g. discovery.NewEtcdSource) hold
+	// a resource that should be released immediately on a clean shutdown
+	// rather than left to expire on its own (a lease TTL, a lock, etc).
+	if closer, ok := src.(interface{ Close() error }); ok {
+		if err := closer.Close(); err != nil {
+			log.Err.Println("ssgrpc: discovery source close error:", err)
+		}
+	}
+
+	for _, pc := range b.peers.clients() {
+		pc.conn.Close()
+	}
+
+	if b.server != nil {
+		b.server.GracefulStop()
+	}
+
+	if b.serverCreds != nil {
+		b.serverCreds.Stop()
+	}
+	if b.clientCreds != nil {
+		b.clientCreds.Stop()
+	}
+
+	return nil
+}
+
+// Reload rebuilds this Backend's TLS configuration from the key/cert/CA
+// files on disk. It's already done automatically on a timer (see
+// watchInterval), so this is only useful to force a reload immediately,
+// e.g. from an admin endpoint right after rotating a certificate. It's a
+// no-op if the Backend was constructed without TLS (NewInsecureBackend).
+func (b *Backend) Reload() error {
+	if b.serverCreds != nil {
+		if err := b.serverCreds.Reload(); err != nil {
+			return err
+		}
+	}
+	if b.clientCreds != nil {
+		if err := b.clientCreds.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCAFile adds a CA bundle to the pool this Backend verifies peer
+// certificates against and reloads its TLS configuration, letting
+// operators add a new CA ahead of a cert rotation without restarting the
+// node. It's a no-op if the Backend was constructed without TLS
+// (NewInsecureBackend).
+func (b *Backend) SetCAFile(caFile string) error {
+	if b.serverCreds != nil {
+		if err := b.serverCreds.SetCAFile(caFile); err != nil {
+			return err
+		}
+	}
+	if b.clientCreds != nil {
+		if err := b.clientCreds.SetCAFile(caFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewBackend returns a Backend that propagates Roomcast, Broadcast, and
+// Socketcast calls to peers over TLS-secured gRPC connections
+// authenticated with a shared-key HMAC JWT. peers is a
+// "peerCN@host:port" list, equivalent to discovery.Static(peers). Pass
+// WithAuthenticator to use a rotating KeySet or an external JWKS issuer
+// instead of sharedKey. keyFile/certFile are watched on disk, the same
+// as NewMutualTLSBackend, so the key pair can be rotated without a
+// restart; unlike NewMutualTLSBackend, peers aren't required to present
+// a client certificate — identity is established by the Authenticator.
+//
+// For clusters where membership changes at runtime, use
+// NewBackendWithSource with a discovery.PeerSource (e.g.
+// discovery.NewEtcdSource) instead of enumerating peers up front.
+func NewBackend(keyFile, certFile, hostPort string, sharedKey []byte, peers []string, opts ...Option) *Backend {
+	serverCreds, clientCreds, err := newTLSCreds(keyFile, certFile)
+	if err != nil {
+		log.Err.Println("ssgrpc: tls setup error, falling back to insecure:", err)
+		return newBackend(hostPort, sharedKey, peers, nil, nil, opts...)
+	}
+
+	return newBackend(hostPort, sharedKey, peers, serverCreds, clientCreds, opts...)
+}
+
+// NewInsecureBackend is NewBackend without TLS or peer authentication.
+// It should not be used on production instances.
+func NewInsecureBackend(hostPort string, peers []string, opts ...Option) *Backend {
+	return newBackend(hostPort, nil, peers, nil, nil, opts...)
+}
+
+// NewBackendWithSource is NewBackend, but peers are discovered
+// dynamically via src instead of being fixed at startup.
+func NewBackendWithSource(keyFile, certFile, hostPort string, sharedKey []byte, src discovery.PeerSource, opts ...Option) *Backend {
+	serverCreds, clientCreds, err := newTLSCreds(keyFile, certFile)
+	if err != nil {
+		log.Err.Println("ssgrpc: tls setup error, falling back to insecure:", err)
+		serverCreds, clientCreds = nil, nil
+	}
+
+	b := newBackend(hostPort, sharedKey, nil, serverCreds, clientCreds, opts...)
+	b.startDiscovery(src)
+	return b
+}
+
+// NewInsecureBackendWithSource is NewInsecureBackend, but peers are
+// discovered dynamically via src instead of being fixed at startup.
+func NewInsecureBackendWithSource(hostPort string, src discovery.PeerSource, opts ...Option) *Backend {
+	b := newBackend(hostPort, nil, nil, nil, nil, opts...)
+	b.startDiscovery(src)
+	return b
+}
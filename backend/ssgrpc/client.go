@@ -1,12 +1,164 @@
 package ssgrpc
 
 import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/raz-varren/log"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/discovery"
 	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
 	"google.golang.org/grpc"
-	//"sync"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type propagateClient struct {
 	conn   *grpc.ClientConn
 	client transport.PropagateClient
+	health *peerHealth
+	queue  *outboundQueue
+
+	cancel context.CancelFunc
+}
+
+// peerManager keeps a propagateClient connected for every peer a
+// discovery.PeerSource currently reports, dialing new peers as they're
+// added and tearing down connections for peers that are removed. This
+// lets the backend's peer set grow and shrink at runtime instead of
+// being fixed for the lifetime of the process.
+type peerManager struct {
+	dial      func(peer string) (*propagateClient, error)
+	newQueue  func(peer string) (*outboundQueue, error)
+	runSender func(ctx context.Context, pc *propagateClient)
+
+	l     sync.Mutex
+	peers map[string]*propagateClient
+}
+
+func newPeerManager(dial func(peer string) (*propagateClient, error), newQueue func(peer string) (*outboundQueue, error), runSender func(ctx context.Context, pc *propagateClient)) *peerManager {
+	return &peerManager{
+		dial:      dial,
+		newQueue:  newQueue,
+		runSender: runSender,
+		peers:     make(map[string]*propagateClient),
+	}
+}
+
+// run consumes PeerEvents from src until ctx is canceled, connecting or
+// disconnecting peers as they arrive.
+func (pm *peerManager) run(ctx context.Context, src discovery.PeerSource) {
+	for ev := range src.Watch(ctx) {
+		switch ev.Type {
+		case discovery.Add:
+			pm.add(ev.Peer)
+		case discovery.Remove:
+			pm.remove(ev.Peer)
+		}
+	}
+}
+
+func (pm *peerManager) add(peer string) {
+	pm.l.Lock()
+	defer pm.l.Unlock()
+
+	if _, ok := pm.peers[peer]; ok {
+		return
+	}
+
+	pc, err := pm.dial(peer)
+	if err != nil {
+		log.Err.Println("ssgrpc: dial peer", peer, "error:", err)
+		return
+	}
+
+	pc.health = newPeerHealth()
+
+	queue, err := pm.newQueue(peer)
+	if err != nil {
+		log.Err.Println("ssgrpc: open queue for peer", peer, "error:", err)
+		pc.conn.Close()
+		return
+	}
+	pc.queue = queue
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pc.cancel = cancel
+	go watchHealth(ctx, healthpb.NewHealthClient(pc.conn), pc.health)
+	go pm.runSender(ctx, pc)
+
+	pm.peers[peer] = pc
+	log.Info.Println("ssgrpc: peer added:", peer)
+}
+
+func (pm *peerManager) remove(peer string) {
+	pm.l.Lock()
+	defer pm.l.Unlock()
+
+	pc, ok := pm.peers[peer]
+	if !ok {
+		return
+	}
+
+	pc.cancel()
+	pc.conn.Close()
+	if err := pc.queue.close(); err != nil {
+		log.Err.Println("ssgrpc: close queue for peer", peer, "error:", err)
+	}
+	delete(pm.peers, peer)
+	log.Info.Println("ssgrpc: peer removed:", peer)
+}
+
+// clients returns a snapshot of the currently connected peer clients.
+func (pm *peerManager) clients() []*propagateClient {
+	pm.l.Lock()
+	defer pm.l.Unlock()
+
+	clients := make([]*propagateClient, 0, len(pm.peers))
+	for _, pc := range pm.peers {
+		clients = append(clients, pc)
+	}
+
+	return clients
+}
+
+// clientsByPeer returns a snapshot of the currently connected peer
+// clients keyed by their "peerCN@host:port" string.
+func (pm *peerManager) clientsByPeer() map[string]*propagateClient {
+	pm.l.Lock()
+	defer pm.l.Unlock()
+
+	clients := make(map[string]*propagateClient, len(pm.peers))
+	for peer, pc := range pm.peers {
+		clients[peer] = pc
+	}
+
+	return clients
+}
+
+// flush waits for every peer's outbound queue to drain (i.e. every
+// message currently queued has been acked) or ctx to be canceled,
+// whichever comes first. It's used during graceful shutdown so in-flight
+// propagate messages aren't lost.
+func (pm *peerManager) flush(ctx context.Context) error {
+	for _, pc := range pm.clients() {
+		if err := pc.queue.flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitPeerCN splits a "peerCN@host:port" string into its common name
+// and host:port parts. If no "@" is present, the host part of host:port
+// is used as the common name, matching the behavior documented on the
+// -peers flag.
+func splitPeerCN(peer string) (cn, hostPort string) {
+	if i := strings.Index(peer, "@"); i >= 0 {
+		return peer[:i], peer[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host, peer
+	}
+	return peer, peer
 }
@@ -0,0 +1,20 @@
+package ssgrpc
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
+)
+
+// encodePropagateMsg/decodePropagateMsg are how outboundQueue persists a
+// queued transport.PropagateMsg to (and restores it from) the WAL.
+func encodePropagateMsg(msg *transport.PropagateMsg) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func decodePropagateMsg(b []byte) (*transport.PropagateMsg, error) {
+	var msg transport.PropagateMsg
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
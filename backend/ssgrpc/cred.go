@@ -1,50 +1,33 @@
 package ssgrpc
 
 import (
-	"sync"
-	"time"
-
 	"github.com/raz-varren/log"
-	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/token"
 	"golang.org/x/net/context"
 )
 
+// perRPCCreds attaches the bearer token an Authenticator produces to
+// every outbound peer RPC. It used to hard-code HMAC-SHA256 signing
+// against a single sharedKey; that's now just the default Authenticator
+// (see NewHMACAuthenticator), selectable via WithAuthenticator so
+// operators can plug in their own KMS or an OIDC issuer instead.
 type perRPCCreds struct {
-	tokenStr    string
-	tokenExpire int64
-	sharedKey   []byte
-	l           *sync.RWMutex
+	auth Authenticator
+
+	// secure is true when the dial these credentials are attached to
+	// actually negotiates transport security (TLS or mutual TLS). gRPC
+	// rejects any RPC where RequireTransportSecurity returns true over an
+	// insecure channel, so this must stay in sync with how the peer
+	// connection was dialed instead of always claiming security.
+	secure bool
 }
 
 func (c *perRPCCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	var tok string
-	var exp int64
-	var sharedKey []byte
-
-	c.l.RLock()
-	exp = c.tokenExpire
-	tok = c.tokenStr
-	sharedKey = c.sharedKey
-	c.l.RUnlock()
-
 	meta := make(map[string]string)
 
-	if exp-300 < time.Now().Unix() {
-		u, t, err := token.GenUserToken("ssgrpcClient", time.Hour, sharedKey)
-		if err != nil {
-			log.Err.Println("gen token error:", err)
-			return meta, err
-		}
-
-		exp = u.EXP
-		tok = t
-
-		c.l.Lock()
-		c.tokenExpire = exp
-		c.tokenStr = tok
-		c.l.Unlock()
-
-		log.Info.Println("token refreshed")
+	tok, err := c.auth.SignOutbound(ctx)
+	if err != nil {
+		log.Err.Println("gen token error:", err)
+		return meta, err
 	}
 
 	meta["authorization"] = "Bearer " + tok
@@ -53,5 +36,5 @@ func (c *perRPCCreds) GetRequestMetadata(ctx context.Context, uri ...string) (ma
 }
 
 func (c *perRPCCreds) RequireTransportSecurity() bool {
-	return true
+	return c.secure
 }
@@ -0,0 +1,36 @@
+// Package discovery provides pluggable peer discovery for the ssgrpc
+// backend. A PeerSource tells the backend which peers exist and notifies
+// it as they come and go, so clusters can be scaled up or down without
+// redeploying nodes with a new -peers flag.
+package discovery
+
+import "context"
+
+// EventType describes whether a PeerEvent is announcing a peer or
+// withdrawing one.
+type EventType int
+
+const (
+	// Add means the peer described by the event should be connected to.
+	Add EventType = iota
+	// Remove means the peer described by the event has gone away and any
+	// connection to it should be torn down.
+	Remove
+)
+
+// PeerEvent describes a single change to cluster membership. Peer is in
+// the same "peerCN@host:port" form accepted by the static -peers flag.
+type PeerEvent struct {
+	Type EventType
+	Peer string
+}
+
+// PeerSource discovers ssgrpc peers and notifies callers as membership
+// changes. Implementations should close the returned channel when ctx is
+// canceled.
+type PeerSource interface {
+	// Watch starts discovery and returns a channel of membership changes.
+	// The first events delivered should be an Add for every peer already
+	// known, so callers don't need a separate "list" call to bootstrap.
+	Watch(ctx context.Context) <-chan PeerEvent
+}
@@ -0,0 +1,212 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raz-varren/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// leaseTTL is how long the local node's registration survives without a
+// heartbeat. It's kept short, a few heartbeat intervals, so a crashed
+// node is reaped from the cluster quickly.
+const leaseTTL = 10 * time.Second
+
+// heartbeatInterval approximates how often the lease backing the local
+// node's key is refreshed. clientv3's KeepAlive renews on its own
+// schedule (about a third of leaseTTL); this constant isn't passed to it.
+const heartbeatInterval = 3 * time.Second
+
+// registerRetryBackoff is how long run waits before re-registering after
+// a registration attempt ends, e.g. because the lease was lost to a
+// network partition.
+const registerRetryBackoff = time.Second
+
+// EtcdSource discovers peers by registering the local node under a key
+// prefix in etcd and watching that prefix for other nodes. Each node's
+// key is kept alive with a lease so that a node which stops heartbeating
+// (crash, network partition) is automatically removed from the prefix
+// and the rest of the cluster sees a Remove event for it.
+type EtcdSource struct {
+	client    *clientv3.Client
+	keyPrefix string
+	localPeer string
+	localKey  string
+
+	l       sync.Mutex
+	leaseID clientv3.LeaseID
+}
+
+// NewEtcdSource returns a PeerSource backed by etcd v3. keyPrefix
+// namespaces the discovery keys (e.g. "/sacrificial-socket/peers/"), and
+// localPeer is this node's own "peerCN@host:port" string, which is
+// registered under keyPrefix so other nodes discover it.
+func NewEtcdSource(client *clientv3.Client, keyPrefix, localPeer string) *EtcdSource {
+	if !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+
+	return &EtcdSource{
+		client:    client,
+		keyPrefix: keyPrefix,
+		localPeer: localPeer,
+		localKey:  keyPrefix + localPeer,
+	}
+}
+
+// Watch registers the local node, starts the heartbeat goroutine, and
+// watches keyPrefix for other nodes joining or leaving. It satisfies
+// discovery.PeerSource.
+func (e *EtcdSource) Watch(ctx context.Context) <-chan PeerEvent {
+	ch := make(chan PeerEvent)
+
+	go e.run(ctx, ch)
+
+	return ch
+}
+
+// run keeps the local node registered for as long as ctx is live,
+// re-registering with a fresh lease (and re-listing/re-watching the
+// prefix from scratch) whenever a registration attempt ends — including
+// when KeepAlive's channel closes because the lease was lost to a
+// network partition, which would otherwise silently stop the node from
+// being discoverable without it noticing.
+func (e *EtcdSource) run(ctx context.Context, ch chan<- PeerEvent) {
+	defer close(ch)
+
+	for {
+		if err := e.runOnce(ctx, ch); err != nil {
+			log.Err.Println("etcd discovery: registration attempt failed, retrying:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registerRetryBackoff):
+		}
+	}
+}
+
+// runOnce registers the local node, lists existing peers, and watches
+// keyPrefix until attemptCtx is canceled — either because ctx itself was
+// canceled, or because the lease backing this registration was lost, in
+// which case run loops around to register again.
+func (e *EtcdSource) runOnce(ctx context.Context, ch chan<- PeerEvent) error {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := e.register(attemptCtx, cancel); err != nil {
+		return err
+	}
+
+	rev, err := e.listExisting(attemptCtx, ch)
+	if err != nil {
+		return err
+	}
+
+	e.watchPrefix(attemptCtx, ch, rev)
+
+	return nil
+}
+
+// register puts the local node's key under a lease and keeps the lease
+// alive for as long as ctx is live, so the node's membership expires
+// automatically on crash. If the KeepAlive channel closes before ctx is
+// done (the lease was revoked out from under it, or lost to a network
+// partition), onLost is called so the caller can re-register instead of
+// leaving the node permanently undiscoverable. A clean shutdown should
+// still call Close to revoke the lease immediately instead of waiting on
+// other nodes to notice it stopped heartbeating.
+func (e *EtcdSource) register(ctx context.Context, onLost context.CancelFunc) error {
+	lease, err := e.client.Grant(ctx, int64(leaseTTL.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.Put(ctx, e.localKey, e.localPeer, clientv3.WithLease(lease.ID))
+	if err != nil {
+		return err
+	}
+
+	keepAlive, err := e.client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+
+	e.l.Lock()
+	e.leaseID = lease.ID
+	e.l.Unlock()
+
+	go func() {
+		for range keepAlive {
+			// draining keeps the lease alive; nothing else to do per tick
+		}
+		onLost()
+	}()
+
+	return nil
+}
+
+// Close revokes the local node's lease, removing its key from keyPrefix
+// immediately rather than leaving it for other nodes to notice via TTL
+// expiry, which can take up to leaseTTL. It's a no-op if Watch was never
+// called (and so no lease was ever granted).
+func (e *EtcdSource) Close() error {
+	e.l.Lock()
+	leaseID := e.leaseID
+	e.l.Unlock()
+
+	if leaseID == 0 {
+		return nil
+	}
+
+	_, err := e.client.Revoke(context.Background(), leaseID)
+	return err
+}
+
+// listExisting sends an Add event for every peer already registered
+// under keyPrefix and returns the revision of the Get, so the caller can
+// start watchPrefix from the next revision instead of from "now" — which
+// would otherwise miss (or double-deliver) any Put/Delete that lands in
+// the gap between this Get and the Watch call starting.
+func (e *EtcdSource) listExisting(ctx context.Context, ch chan<- PeerEvent) (int64, error) {
+	resp, err := e.client.Get(ctx, e.keyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, kv := range resp.Kvs {
+		if string(kv.Key) == e.localKey {
+			continue
+		}
+		ch <- PeerEvent{Type: Add, Peer: string(kv.Value)}
+	}
+
+	return resp.Header.Revision, nil
+}
+
+// watchPrefix watches keyPrefix starting just after rev (the revision
+// listExisting observed), so every change is seen exactly once across
+// the list-then-watch handoff.
+func (e *EtcdSource) watchPrefix(ctx context.Context, ch chan<- PeerEvent, rev int64) {
+	watchChan := e.client.Watch(ctx, e.keyPrefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+
+	for resp := range watchChan {
+		for _, ev := range resp.Events {
+			if string(ev.Kv.Key) == e.localKey {
+				continue
+			}
+
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				ch <- PeerEvent{Type: Add, Peer: string(ev.Kv.Value)}
+			case clientv3.EventTypeDelete:
+				peer := strings.TrimPrefix(string(ev.Kv.Key), e.keyPrefix)
+				ch <- PeerEvent{Type: Remove, Peer: peer}
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package discovery
+
+import "context"
+
+// staticSource is a PeerSource that announces a fixed set of peers once
+// and never changes. It exists so the backend can keep treating the
+// legacy comma-separated -peers flag as just another PeerSource.
+type staticSource struct {
+	peers []string
+}
+
+// Static returns a PeerSource that announces peers once and is otherwise
+// inert, preserving the behavior of the old static -peers flag.
+func Static(peers []string) PeerSource {
+	return &staticSource{peers: peers}
+}
+
+func (s *staticSource) Watch(ctx context.Context) <-chan PeerEvent {
+	ch := make(chan PeerEvent, len(s.peers))
+
+	for _, p := range s.peers {
+		ch <- PeerEvent{Type: Add, Peer: p}
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch
+}
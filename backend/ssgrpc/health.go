@@ -0,0 +1,216 @@
+package ssgrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raz-varren/log"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ewmaAlpha weights how quickly PeerStats.RTT reacts to new samples.
+// Higher is more reactive, lower smooths out noise.
+const ewmaAlpha = 0.2
+
+// maxBackoff caps how long a peer is suspended for after repeated
+// failures, so a peer that recovers is retried at least this often.
+const maxBackoff = 30 * time.Second
+
+// errorThreshold is how many consecutive propagate failures a peer can
+// have before it's suspended, independent of what the health service
+// reports.
+const errorThreshold = 3
+
+// PeerStats is a point-in-time snapshot of a peer connection's health,
+// returned by Backend.PeerStats.
+type PeerStats struct {
+	Peer                string
+	Healthy             bool
+	Suspended           bool
+	InFlight            int64
+	RTT                 time.Duration
+	ConsecutiveFailures int
+}
+
+// peerHealth tracks the health of a single peer connection: the standard
+// grpc health status, an EWMA of propagate RTT, consecutive failures,
+// and whether broadcasts to it are currently suspended.
+type peerHealth struct {
+	inFlight int64 // accessed atomically
+
+	l                   sync.Mutex
+	healthy             bool
+	rtt                 time.Duration
+	consecutiveFailures int
+	suspendedUntil      time.Time
+	backoff             time.Duration
+}
+
+func newPeerHealth() *peerHealth {
+	return &peerHealth{healthy: true}
+}
+
+func (ph *peerHealth) recordSuccess(rtt time.Duration) {
+	atomic.AddInt64(&ph.inFlight, -1)
+
+	ph.l.Lock()
+	defer ph.l.Unlock()
+
+	if ph.rtt == 0 {
+		ph.rtt = rtt
+	} else {
+		ph.rtt = time.Duration(ewmaAlpha*float64(rtt) + (1-ewmaAlpha)*float64(ph.rtt))
+	}
+
+	ph.consecutiveFailures = 0
+	ph.backoff = 0
+	ph.suspendedUntil = time.Time{}
+}
+
+func (ph *peerHealth) recordFailure() {
+	ph.l.Lock()
+	defer ph.l.Unlock()
+
+	ph.consecutiveFailures++
+
+	if ph.consecutiveFailures < errorThreshold {
+		return
+	}
+
+	if ph.backoff == 0 {
+		ph.backoff = time.Second
+	} else {
+		ph.backoff *= 2
+		if ph.backoff > maxBackoff {
+			ph.backoff = maxBackoff
+		}
+	}
+
+	ph.suspendedUntil = time.Now().Add(ph.backoff)
+}
+
+func (ph *peerHealth) setHealthy(healthy bool) {
+	ph.l.Lock()
+	ph.healthy = healthy
+	ph.l.Unlock()
+
+	if !healthy {
+		log.Err.Println("ssgrpc: peer reported NOT_SERVING, suspending broadcasts")
+	}
+}
+
+func (ph *peerHealth) beginCall() {
+	atomic.AddInt64(&ph.inFlight, 1)
+}
+
+// release decrements inFlight by n, for messages that were sent (and so
+// counted by beginCall) but never individually resolved by
+// recordSuccess because the stream they were sent on tore down first
+// (recordFailure counts a failed stream, not its messages). Without this, inFlight would drift upward by one for
+// every broken stream instead of reflecting what's actually outstanding.
+func (ph *peerHealth) release(n int64) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&ph.inFlight, -n)
+}
+
+func (ph *peerHealth) snapshot() (healthy, suspended bool, inFlight int64, rtt time.Duration, consecutiveFailures int) {
+	ph.l.Lock()
+	defer ph.l.Unlock()
+
+	return ph.healthy, time.Now().Before(ph.suspendedUntil), atomic.LoadInt64(&ph.inFlight), ph.rtt, ph.consecutiveFailures
+}
+
+// watchHealth streams the peer's grpc.health.v1.Health status and keeps
+// ph up to date. It runs for the lifetime of the propagate client and
+// returns when ctx is canceled or the connection is closed for good.
+func watchHealth(ctx context.Context, client healthpb.HealthClient, ph *peerHealth) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "ss.Server"})
+		if err != nil {
+			// Canceled means ctx was canceled or the connection was closed.
+			if status.Code(err) == codes.Canceled {
+				return
+			}
+			ph.setHealthy(false)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				ph.setHealthy(false)
+				break
+			}
+
+			ph.setHealthy(resp.Status == healthpb.HealthCheckResponse_SERVING)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// healthServer implements grpc.health.v1.Health for the local ss.Server,
+// reporting SERVING while it's up and NOT_SERVING once shutdown begins.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	l      sync.Mutex
+	status healthpb.HealthCheckResponse_ServingStatus
+}
+
+func newHealthServer() *healthServer {
+	return &healthServer{status: healthpb.HealthCheckResponse_SERVING}
+}
+
+func (h *healthServer) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	h.l.Lock()
+	h.status = status
+	h.l.Unlock()
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return &healthpb.HealthCheckResponse{Status: h.status}, nil
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	last := healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+
+	for {
+		h.l.Lock()
+		status := h.status
+		h.l.Unlock()
+
+		if status != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+			last = status
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
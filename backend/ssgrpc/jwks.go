@@ -0,0 +1,188 @@
+package ssgrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwksRefreshTimeout bounds how long a JWKS fetch on a kid cache miss is
+// allowed to take, so a slow or unreachable issuer can't stall
+// VerifyInbound indefinitely.
+const jwksRefreshTimeout = 5 * time.Second
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Crv string `json:"crv"`
+}
+
+type jwksDoc struct {
+	Keys []jwk `json:"keys"`
+}
+
+// JWKSAuthenticator verifies inbound tokens signed with RS256 or ES256
+// against keys published at a JWKS URL (e.g. an OIDC issuer's
+// .well-known/jwks.json), so peer identity can be delegated to an
+// external IdP instead of a shared secret. It has no outbound signing
+// key of its own; pair it with another Authenticator (or a
+// client-credentials flow outside ssgrpc) to mint outbound tokens.
+type JWKSAuthenticator struct {
+	jwksURL string
+	client  *http.Client
+
+	l    sync.RWMutex
+	keys map[string]interface{} // kid -> *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// NewJWKSAuthenticator returns a JWKSAuthenticator that fetches keys
+// from jwksURL, refreshing its cache whenever VerifyInbound sees a kid
+// it doesn't recognize.
+func NewJWKSAuthenticator(jwksURL string) *JWKSAuthenticator {
+	return &JWKSAuthenticator{
+		jwksURL: jwksURL,
+		client:  &http.Client{Timeout: jwksRefreshTimeout},
+		keys:    make(map[string]interface{}),
+	}
+}
+
+func (j *JWKSAuthenticator) SignOutbound(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("ssgrpc: JWKSAuthenticator cannot sign outbound tokens, pair it with another Authenticator")
+}
+
+func (j *JWKSAuthenticator) VerifyInbound(tokenStr string) (Claims, error) {
+	var claims jwt.StandardClaims
+
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, ok := j.key(kid)
+		if !ok {
+			if err := j.refresh(); err != nil {
+				return nil, err
+			}
+
+			key, ok = j.key(kid)
+			if !ok {
+				return nil, fmt.Errorf("ssgrpc: no jwks key for kid %q", kid)
+			}
+		}
+
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("ssgrpc: unsupported signing method %v", t.Header["alg"])
+		}
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{Subject: claims.Subject, Expiry: claims.ExpiresAt}, nil
+}
+
+func (j *JWKSAuthenticator) key(kid string) (interface{}, bool) {
+	j.l.RLock()
+	defer j.l.RUnlock()
+
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh re-fetches the JWKS document and replaces the key cache. It's
+// called on a kid cache miss rather than on a timer, so a newly rotated
+// key is picked up on first use instead of waiting for the next poll.
+func (j *JWKSAuthenticator) refresh() error {
+	resp, err := j.client.Get(j.jwksURL)
+	if err != nil {
+		return fmt.Errorf("ssgrpc: fetch jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var doc jwksDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("ssgrpc: decode jwks: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(doc.Keys))
+
+	for _, k := range doc.Keys {
+		pub, err := parseJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	j.l.Lock()
+	j.keys = keys
+	j.l.Unlock()
+
+	return nil
+}
+
+func parseJWK(k jwk) (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := jwt.DecodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwt.DecodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		eInt := 0
+		for _, b := range e {
+			eInt = eInt<<8 + int(b)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: eInt,
+		}, nil
+	case "EC":
+		x, err := jwt.DecodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwt.DecodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("ssgrpc: unsupported jwk crv %q", k.Crv)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("ssgrpc: unsupported jwk kty %q", k.Kty)
+	}
+}
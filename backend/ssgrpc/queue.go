@@ -0,0 +1,204 @@
+package ssgrpc
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/raz-varren/log"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/wal"
+)
+
+// DropPolicy controls what an outboundQueue does when it's full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest unacked message to make room for a
+	// new one, favoring producers (Roomcast/Broadcast/Socketcast callers)
+	// never blocking over strict delivery of every message.
+	DropOldest DropPolicy = iota
+	// BlockProducer blocks the caller until an ack frees up space,
+	// favoring not losing messages over producer latency.
+	BlockProducer
+)
+
+// QueueOptions configures a peer's outbound durable queue.
+type QueueOptions struct {
+	// Dir is where the queue's WAL segments are written. Each peer gets
+	// its own subdirectory under Dir.
+	Dir string
+	// MaxSize is how many unacked messages a peer's queue holds before
+	// Policy kicks in. Zero means unbounded.
+	MaxSize int
+	// Policy is applied once a queue reaches MaxSize.
+	Policy DropPolicy
+}
+
+// outboundMsg is a single queued propagate call, tagged with a
+// monotonic sequence number scoped to (originNodeID, streamID) so a
+// receiving peer can dedup retransmits after a reconnect.
+type outboundMsg struct {
+	seq     uint64
+	payload *transport.PropagateMsg
+	queued  chan struct{} // closed once acked
+}
+
+// outboundQueue is a single peer's durable, ordered outbound queue. A
+// dedicated goroutine drains it over an ack'd streaming RPC; messages
+// stay queued (and on disk, via the WAL) until acked, so a dropped
+// stream can be resumed from the last ack without losing or
+// re-delivering anything the peer already has.
+type outboundQueue struct {
+	peer     string
+	streamID string
+	opts     QueueOptions
+	log      *wal.Log
+
+	l         sync.Mutex
+	cond      *sync.Cond
+	pending   []*outboundMsg
+	nextSeq   uint64
+	lastAcked uint64
+}
+
+func newOutboundQueue(peer, originNodeID string, opts QueueOptions) (*outboundQueue, error) {
+	q := &outboundQueue{
+		peer:     peer,
+		streamID: originNodeID + "/" + peer,
+		opts:     opts,
+	}
+	q.cond = sync.NewCond(&q.l)
+
+	if opts.Dir != "" {
+		l, err := wal.Open(filepath.Join(opts.Dir, sanitizePeerDir(peer)))
+		if err != nil {
+			return nil, err
+		}
+		q.log = l
+
+		if err := l.Replay(func(rec wal.Record) error {
+			msg, err := decodePropagateMsg(rec.Payload)
+			if err != nil {
+				return err
+			}
+			q.pending = append(q.pending, &outboundMsg{seq: rec.Seq, payload: msg, queued: make(chan struct{})})
+			if rec.Seq >= q.nextSeq {
+				q.nextSeq = rec.Seq + 1
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// enqueue assigns msg the next sequence number, persists it to the WAL,
+// and adds it to the pending list, applying the queue's DropPolicy if
+// it's already at MaxSize.
+func (q *outboundQueue) enqueue(msg *transport.PropagateMsg) *outboundMsg {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	for q.opts.MaxSize > 0 && len(q.pending) >= q.opts.MaxSize {
+		switch q.opts.Policy {
+		case DropOldest:
+			dropped := q.pending[0]
+			q.pending = q.pending[1:]
+			close(dropped.queued)
+			log.Err.Println("ssgrpc: queue full, dropped oldest message to peer", q.peer)
+		case BlockProducer:
+			q.cond.Wait()
+			continue
+		}
+		break
+	}
+
+	om := &outboundMsg{seq: q.nextSeq, payload: msg, queued: make(chan struct{})}
+	msg.Seq = om.seq
+	q.nextSeq++
+
+	if q.log != nil {
+		if payload, err := encodePropagateMsg(msg); err == nil {
+			if err := q.log.Append(wal.Record{Seq: om.seq, Payload: payload}); err != nil {
+				log.Err.Println("ssgrpc: wal append error:", err)
+			}
+		}
+	}
+
+	q.pending = append(q.pending, om)
+
+	return om
+}
+
+// snapshot returns every currently unacked message, oldest first, for
+// the stream sender goroutine to (re)send in order.
+func (q *outboundQueue) snapshot() []*outboundMsg {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	out := make([]*outboundMsg, len(q.pending))
+	copy(out, q.pending)
+	return out
+}
+
+// ack removes every message up to and including seq from the pending
+// list, since the peer has confirmed it received them, and wakes any
+// producer blocked on BlockProducer.
+func (q *outboundQueue) ack(seq uint64) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	i := 0
+	for ; i < len(q.pending); i++ {
+		if q.pending[i].seq > seq {
+			break
+		}
+		close(q.pending[i].queued)
+	}
+	q.pending = q.pending[i:]
+	q.lastAcked = seq
+
+	if q.log != nil && len(q.pending) == 0 {
+		if err := q.log.Compact(); err != nil {
+			log.Err.Println("ssgrpc: wal compact error:", err)
+		}
+	}
+
+	q.cond.Broadcast()
+}
+
+// flush blocks until every currently queued message has been acked or
+// ctx is canceled, whichever comes first.
+func (q *outboundQueue) flush(ctx context.Context) error {
+	for _, om := range q.snapshot() {
+		select {
+		case <-om.queued:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (q *outboundQueue) close() error {
+	if q.log == nil {
+		return nil
+	}
+	return q.log.Close()
+}
+
+func sanitizePeerDir(peer string) string {
+	out := make([]rune, 0, len(peer))
+	for _, r := range peer {
+		switch r {
+		case '/', '@', ':':
+			out = append(out, '_')
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
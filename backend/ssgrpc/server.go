@@ -0,0 +1,150 @@
+package ssgrpc
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/raz-varren/log"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
+)
+
+// Deliverer is implemented by ss.Server (or a stand-in for it, e.g. in
+// tests) to receive propagated messages from peers. Backend.SetDeliverer
+// wires one into a Backend's propagateServer; until it does, accepted
+// messages are logged and dropped instead of reaching local sockets.
+type Deliverer interface {
+	DeliverPropagate(kind transport.PropagateMsg_Kind, room, socketID string, data []byte)
+}
+
+// propagateServer is the receiving side of the ack'd PropagateStream
+// RPC. It tracks the highest sequence number seen per (originNodeId,
+// streamId) so that a peer resending unacked messages after a
+// reconnect doesn't get delivered twice, and acks every message it
+// accepts so the sender can drop it from its outbound queue.
+type propagateServer struct {
+	transport.UnimplementedPropagateServer
+
+	dl sync.RWMutex
+	d  Deliverer
+
+	// statePath, if non-empty, is where highSeq is persisted so a
+	// receiver restart doesn't forget which sequences it already
+	// accepted and re-deliver them to local sockets.
+	statePath string
+
+	l       sync.Mutex
+	highSeq map[string]uint64
+}
+
+// newPropagateServer returns a propagateServer that persists its dedup
+// state to statePath after every accepted message, loading whatever was
+// last written there on startup. Pass "" to keep the dedup state
+// in-memory only (a receiver restart can then re-deliver whatever the
+// sender hadn't gotten an ack for yet).
+func newPropagateServer(statePath string) (*propagateServer, error) {
+	s := &propagateServer{
+		statePath: statePath,
+		highSeq:   make(map[string]uint64),
+	}
+
+	if statePath == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.highSeq); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// saveState persists highSeq to statePath. Must be called with l held.
+func (s *propagateServer) saveState() {
+	data, err := json.Marshal(s.highSeq)
+	if err != nil {
+		log.Err.Println("ssgrpc: marshal propagate dedup state error:", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(s.statePath, data, 0o644); err != nil {
+		log.Err.Println("ssgrpc: persist propagate dedup state error:", err)
+	}
+}
+
+// setDeliverer swaps the Deliverer accepted messages are handed to.
+func (s *propagateServer) setDeliverer(d Deliverer) {
+	s.dl.Lock()
+	s.d = d
+	s.dl.Unlock()
+}
+
+func (s *propagateServer) deliver(msg *transport.PropagateMsg) {
+	s.dl.RLock()
+	d := s.d
+	s.dl.RUnlock()
+
+	if d == nil {
+		log.Info.Println("ssgrpc: propagate delivered with no ss.Server attached, dropping:", msg.Kind)
+		return
+	}
+
+	d.DeliverPropagate(msg.Kind, msg.Room, msg.SocketId, msg.Data)
+}
+
+// PropagateStream receives an ordered stream of messages from a peer,
+// acking each one it accepts (or already saw) and delivering any it
+// hasn't seen before.
+func (s *propagateServer) PropagateStream(stream transport.Propagate_PropagateStreamServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.accept(msg) {
+			s.deliver(msg)
+		}
+
+		if err := stream.Send(&transport.PropagateAck{Seq: msg.Seq}); err != nil {
+			return err
+		}
+	}
+}
+
+// accept reports whether msg is new for its (originNodeId, streamId)
+// scope, i.e. its seq is greater than the highest one already seen.
+// Duplicates are still ack'd by the caller so a sender that's replaying
+// from an old ack position converges quickly.
+func (s *propagateServer) accept(msg *transport.PropagateMsg) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	key := msg.OriginNodeId + "/" + msg.StreamId
+
+	high, seen := s.highSeq[key]
+	if seen && msg.Seq <= high {
+		return false
+	}
+
+	s.highSeq[key] = msg.Seq
+
+	if s.statePath != "" {
+		s.saveState()
+	}
+
+	return true
+}
@@ -0,0 +1,126 @@
+package ssgrpc
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/raz-varren/log"
+	"github.com/susilolab/sacrificial-socket/backend/ssgrpc/transport"
+)
+
+// streamRetryBackoff is how long runSender waits before reopening the
+// PropagateStream after it breaks, e.g. because the peer restarted.
+const streamRetryBackoff = time.Second
+
+// runSender drains pc's outbound queue over an ack'd PropagateStream,
+// resending whatever's still unacked if the stream breaks and has to be
+// reopened. This is what gives Propagate at-least-once delivery: a
+// message only leaves the queue once the peer has ack'd its seq, so a
+// dropped connection just means a retry, not data loss.
+func runSender(ctx context.Context, pc *propagateClient) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// A peer reporting NOT_SERVING, or inside its failure backoff
+		// window (see peerHealth.recordFailure), isn't sent to until it
+		// recovers; its queue keeps accumulating meanwhile.
+		if healthy, suspended, _, _, _ := pc.health.snapshot(); healthy && !suspended {
+			if err := sendOnce(ctx, pc); err != nil {
+				log.Err.Println("ssgrpc: propagate stream error:", err)
+				pc.health.recordFailure()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamRetryBackoff):
+		}
+	}
+}
+
+func sendOnce(ctx context.Context, pc *propagateClient) error {
+	stream, err := pc.client.PropagateStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	acks := make(chan uint64)
+	errs := make(chan error, 1)
+
+	sent := make(map[uint64]time.Time)
+	// Whatever's still in sent when this stream tears down was counted by
+	// beginCall but will never be resolved by recordSuccess on this
+	// attempt, so release it here instead of leaking it into
+	// PeerStats.InFlight.
+	defer func() {
+		pc.health.release(int64(len(sent)))
+	}()
+
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					errs <- err
+				}
+				close(acks)
+				return
+			}
+			acks <- ack.Seq
+		}
+	}()
+
+	for {
+		for _, om := range pc.queue.snapshot() {
+			if _, ok := sent[om.seq]; ok {
+				continue
+			}
+			if err := stream.Send(om.payload); err != nil {
+				return err
+			}
+			sent[om.seq] = time.Now()
+			pc.health.beginCall()
+		}
+
+		select {
+		case seq, ok := <-acks:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default:
+					return nil
+				}
+			}
+			pc.queue.ack(seq)
+			if start, ok := sent[seq]; ok {
+				pc.health.recordSuccess(time.Since(start))
+				delete(sent, seq)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			// wake up periodically to pick up newly enqueued messages
+		}
+	}
+}
+
+// buildPropagateMsg wraps a roomcast/broadcast/socketcast payload in the
+// envelope carried over PropagateStream, stamping it with the sequence
+// scope (originNodeID, streamID) the receiver dedups on.
+func buildPropagateMsg(originNodeID, streamID string, kind transport.PropagateMsg_Kind, room, socketID string, data []byte) *transport.PropagateMsg {
+	return &transport.PropagateMsg{
+		OriginNodeId: originNodeID,
+		StreamId:     streamID,
+		Kind:         kind,
+		Room:         room,
+		SocketId:     socketID,
+		Data:         data,
+	}
+}
@@ -0,0 +1,84 @@
+// Package token generates and verifies the JWTs used to authenticate
+// ssgrpc peer connections.
+package token
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// User is the subject of a peer-authentication token.
+type User struct {
+	Sub string `json:"sub"`
+	EXP int64  `json:"exp"`
+}
+
+type claims struct {
+	jwt.StandardClaims
+}
+
+// GenUserToken signs a JWT for sub that expires after ttl, using key as
+// the HMAC-SHA256 signing key.
+func GenUserToken(sub string, ttl time.Duration, key []byte) (*User, string, error) {
+	return GenUserTokenWithKid(sub, ttl, key, "")
+}
+
+// GenUserTokenWithKid is GenUserToken, but stamps the token's header with
+// kid so a verifier holding multiple active keys (see KeySet) knows
+// which one to check the signature against without trying them all.
+func GenUserTokenWithKid(sub string, ttl time.Duration, key []byte, kid string) (*User, string, error) {
+	exp := time.Now().Add(ttl).Unix()
+
+	u := &User{Sub: sub, EXP: exp}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		jwt.StandardClaims{
+			Subject:   sub,
+			ExpiresAt: exp,
+		},
+	})
+
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return u, signed, nil
+}
+
+// VerifyUserToken verifies tokenStr was signed with key and hasn't
+// expired, returning its subject.
+func VerifyUserToken(tokenStr string, key []byte) (*User, error) {
+	var c claims
+
+	_, err := jwt.ParseWithClaims(tokenStr, &c, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("token: unexpected signing method %v", t.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{Sub: c.Subject, EXP: c.ExpiresAt}, nil
+}
+
+// Kid returns the "kid" header of tokenStr without verifying its
+// signature, so a verifier holding multiple keys can pick the right one
+// before checking it.
+func Kid(tokenStr string) (string, error) {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(tokenStr, &claims{})
+	if err != nil {
+		return "", err
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+	return kid, nil
+}
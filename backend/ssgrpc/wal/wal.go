@@ -0,0 +1,206 @@
+// Package wal is a minimal durable write-ahead log used to back ssgrpc's
+// per-peer outbound queues: records are appended to a segment file as
+// they're queued, and every segment is discarded at once (see Compact)
+// when a peer has ack'd everything written so far, so a restarting node
+// can replay whatever it never got an ack for instead of losing it.
+// Records aren't removed one ack at a time: acked records written before
+// an unacked one stay on disk and are replayed along with it.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxSegmentBytes is the size a segment grows to before the log rolls
+// over to a new one. Old, fully-acked segments are removed by
+// Compact.
+const maxSegmentBytes = 64 * 1024 * 1024
+
+// Record is a single WAL entry: a message's sequence number and its
+// serialized payload.
+type Record struct {
+	Seq     uint64
+	Payload []byte
+}
+
+// Log is an append-only, segmented on-disk log for a single peer's
+// outbound queue.
+type Log struct {
+	dir string
+
+	l        sync.Mutex
+	segments []string
+	cur      *os.File
+	curBytes int64
+}
+
+// Open opens (creating if necessary) the WAL rooted at dir.
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir}
+	for _, e := range entries {
+		if !e.IsDir() {
+			l.segments = append(l.segments, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	if err := l.rollIfNeeded(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Append durably writes rec to the log, rolling to a new segment first
+// if the current one has grown past maxSegmentBytes.
+func (l *Log) Append(rec Record) error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if err := l.rollIfNeeded(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8+8+len(rec.Payload))
+	binary.BigEndian.PutUint64(buf[0:8], rec.Seq)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(len(rec.Payload)))
+	copy(buf[16:], rec.Payload)
+
+	n, err := l.cur.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	if err := l.cur.Sync(); err != nil {
+		return err
+	}
+
+	l.curBytes += int64(n)
+
+	return nil
+}
+
+func (l *Log) rollIfNeeded() error {
+	if l.cur != nil && l.curBytes < maxSegmentBytes {
+		return nil
+	}
+
+	if l.cur != nil {
+		l.cur.Close()
+	}
+
+	name := filepath.Join(l.dir, fmt.Sprintf("%020d.seg", len(l.segments)))
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.segments = append(l.segments, name)
+	l.cur = f
+	l.curBytes = 0
+
+	return nil
+}
+
+// Replay reads every record still on disk, in seq order, calling fn for
+// each. It's used on startup to resume a queue a node never got an ack
+// for before it restarted.
+func (l *Log) Replay(fn func(Record) error) error {
+	l.l.Lock()
+	segments := append([]string(nil), l.segments...)
+	l.l.Unlock()
+
+	for _, seg := range segments {
+		if err := replaySegment(seg, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replaySegment(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		seq := binary.BigEndian.Uint64(header[0:8])
+		size := binary.BigEndian.Uint64(header[8:16])
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		if err := fn(Record{Seq: seq, Payload: payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// Compact discards every segment on disk, including the current one.
+// Callers must only call it once every record written so far has been
+// confirmed ack'd (ssgrpc's outboundQueue does this exactly when its
+// pending list drains to empty) — otherwise an unacked record written to
+// the still-open current segment would be discarded along with it and
+// lost on restart instead of replayed.
+func (l *Log) Compact() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if l.cur != nil {
+		l.cur.Close()
+		l.cur = nil
+	}
+
+	for _, seg := range l.segments {
+		if err := os.Remove(seg); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	l.segments = nil
+	l.curBytes = 0
+
+	return nil
+}
+
+// Close closes the current segment file.
+func (l *Log) Close() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if l.cur == nil {
+		return nil
+	}
+
+	return l.cur.Close()
+}
@@ -0,0 +1,326 @@
+// Package ssnats is an ss.MultihomeBackend backed by NATS (with
+// JetStream for durable delivery), offered as an alternative to ssgrpc's
+// N² gRPC mesh. Roomcast, Broadcast, and Socketcast calls become
+// publishes onto a subject hierarchy, and every node subscribes with its
+// own queue group so NATS subject routing does the fanout instead of
+// each node holding a connection to every other node. For clusters with
+// thousands of nodes this scales far better than ssgrpc's mesh model.
+package ssnats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/raz-varren/log"
+)
+
+// subject hierarchy: ss.<cluster>.room.<roomname> / ss.<cluster>.socket.<id> / ss.<cluster>.broadcast
+const (
+	roomSubjectFmt      = "ss.%s.room.%s"
+	roomWildcard        = "ss.%s.room.*"
+	socketSubjectFmt    = "ss.%s.socket.%s"
+	socketWildcard      = "ss.%s.socket.*"
+	broadcastSubjectFmt = "ss.%s.broadcast"
+)
+
+// Backend is an ss.MultihomeBackend that publishes Roomcast, Broadcast,
+// and Socketcast calls as NATS messages instead of dialing every peer
+// directly.
+type Backend struct {
+	cluster string
+	nodeID  string
+
+	conn *nats.Conn
+	js   nats.JetStreamContext
+
+	subs []*nats.Subscription
+
+	// d is the Deliverer that receives messages published by peers. Set
+	// via SetDeliverer once the backend is attached to an ss.Server.
+	dl sync.RWMutex
+	d  Deliverer
+}
+
+// Deliverer is implemented by ss.Server (or a stand-in for it, e.g. in
+// tests) to receive messages published by peers. SetDeliverer wires one
+// into a Backend; until it does, received messages are logged and
+// dropped.
+type Deliverer interface {
+	DeliverPropagate(kind Kind, room, socketID string, data []byte)
+}
+
+// Kind tells a Deliverer which of the three cast types a message arrived
+// as. It isn't carried in the message itself: each subscription handler
+// sets it from the subject (broadcast, room, or socket) it arrived on.
+type Kind int
+
+const (
+	KindBroadcast Kind = iota
+	KindRoomcast
+	KindSocketcast
+)
+
+type envelope struct {
+	Room     string `json:"room,omitempty"`
+	SocketID string `json:"socketId,omitempty"`
+	Data     []byte `json:"data"`
+}
+
+// Option configures optional Backend behavior.
+type Option func(*config)
+
+type config struct {
+	natsOpts   []nats.Option
+	streamCfg  *nats.StreamConfig
+	cluster    string
+	queueGroup string
+}
+
+// WithUserPass authenticates to NATS with a username and password.
+func WithUserPass(user, pass string) Option {
+	return func(c *config) {
+		c.natsOpts = append(c.natsOpts, nats.UserInfo(user, pass))
+	}
+}
+
+// WithNKey authenticates to NATS with an nkey seed file.
+func WithNKey(nkeyFile string) Option {
+	return func(c *config) {
+		c.natsOpts = append(c.natsOpts, nats.NkeyOptionFromSeed(nkeyFile))
+	}
+}
+
+// WithUserCredentials authenticates to NATS with a JWT/nkey credentials
+// file, as issued by an NGS or self-hosted NATS operator.
+func WithUserCredentials(credsFile string) Option {
+	return func(c *config) {
+		c.natsOpts = append(c.natsOpts, nats.UserCredentials(credsFile))
+	}
+}
+
+// WithCluster namespaces every subject this backend publishes and
+// subscribes to under ss.<cluster>, so multiple sacrificial-socket
+// clusters can share one NATS deployment without cross-talk.
+func WithCluster(cluster string) Option {
+	return func(c *config) {
+		c.cluster = cluster
+	}
+}
+
+// WithJetStream enables durable, at-least-once delivery by creating (or
+// reusing) a JetStream stream matching cfg before subscribing. Without
+// this option, ssnats uses plain NATS pub/sub (at-most-once, no
+// redelivery if a node is down when a message is published).
+func WithJetStream(cfg nats.StreamConfig) Option {
+	return func(c *config) {
+		c.streamCfg = &cfg
+	}
+}
+
+// WithQueueGroup overrides the queue group this node subscribes with.
+// Each node should use a distinct group so that every node — not just
+// one of a pool — receives each message; the group only protects
+// against double delivery within a single node that opens more than one
+// subscription. Pass a stable value (e.g. the node's hostname) when
+// WithJetStream is also used: the queue group names the JetStream
+// durable consumer, and a process restarting under a different group
+// looks like a brand new consumer with no delivery history, losing the
+// redelivery-on-reconnect guarantee WithJetStream exists for. Without
+// this option the default is a random group, which is fine for plain
+// NATS pub/sub but defeats that guarantee.
+func WithQueueGroup(group string) Option {
+	return func(c *config) {
+		c.queueGroup = group
+	}
+}
+
+// NewBackend connects to the NATS server at url and returns a Backend
+// ready to be passed to ss.Server.SetMultihomeBackend.
+func NewBackend(url string, opts ...Option) (*Backend, error) {
+	cfg := &config{cluster: "default"}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.queueGroup == "" {
+		if cfg.streamCfg != nil {
+			log.Err.Println("ssnats: WithJetStream used without WithQueueGroup, durable consumer name will change on every restart and lose redelivery history; pass a stable WithQueueGroup")
+		}
+		cfg.queueGroup = randomNodeID()
+	}
+
+	conn, err := nats.Connect(url, cfg.natsOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("ssnats: connect: %v", err)
+	}
+
+	b := &Backend{
+		cluster: cfg.cluster,
+		nodeID:  cfg.queueGroup,
+		conn:    conn,
+	}
+
+	if cfg.streamCfg != nil {
+		js, err := conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ssnats: jetstream: %v", err)
+		}
+
+		streamCfg := *cfg.streamCfg
+		if streamCfg.Name == "" {
+			streamCfg.Name = "SS_" + cfg.cluster
+		}
+		if len(streamCfg.Subjects) == 0 {
+			streamCfg.Subjects = []string{fmt.Sprintf("ss.%s.>", cfg.cluster)}
+		}
+
+		if _, err := js.AddStream(&streamCfg); err != nil && err != nats.ErrStreamNameAlreadyInUse {
+			conn.Close()
+			return nil, fmt.Errorf("ssnats: add stream: %v", err)
+		}
+
+		b.js = js
+	}
+
+	if err := b.subscribe(cfg.queueGroup); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// SetDeliverer wires d to receive every message this node gets from a
+// peer. ss.Server should call this right after SetMultihomeBackend;
+// until it does, received messages are logged and dropped.
+func (b *Backend) SetDeliverer(d Deliverer) {
+	b.dl.Lock()
+	b.d = d
+	b.dl.Unlock()
+}
+
+func (b *Backend) deliver(kind Kind, room, socketID string, data []byte) {
+	b.dl.RLock()
+	d := b.d
+	b.dl.RUnlock()
+
+	if d == nil {
+		log.Info.Println("ssnats: message delivered with no ss.Server attached, dropping:", kind)
+		return
+	}
+
+	d.DeliverPropagate(kind, room, socketID, data)
+}
+
+func (b *Backend) subscribe(queueGroup string) error {
+	handlers := []struct {
+		subject string
+		name    string
+		handler func(*nats.Msg)
+	}{
+		{fmt.Sprintf(broadcastSubjectFmt, b.cluster), "broadcast", b.onBroadcast},
+		{fmt.Sprintf(roomWildcard, b.cluster), "room", b.onRoomcast},
+		{fmt.Sprintf(socketWildcard, b.cluster), "socket", b.onSocketcast},
+	}
+
+	for _, h := range handlers {
+		sub, err := b.subscribeOne(h.subject, queueGroup, h.name, h.handler)
+		if err != nil {
+			return fmt.Errorf("ssnats: subscribe %s: %v", h.subject, err)
+		}
+		b.subs = append(b.subs, sub)
+	}
+
+	return nil
+}
+
+// subscribeOne subscribes to subject under queueGroup. When JetStream is
+// enabled it subscribes through b.js with a durable consumer named after
+// the queue group and handler, so a node that's down gets the messages
+// it missed redelivered once it reconnects instead of losing them like a
+// core NATS subscription would; otherwise it falls back to plain NATS
+// pub/sub.
+func (b *Backend) subscribeOne(subject, queueGroup, name string, handler func(*nats.Msg)) (*nats.Subscription, error) {
+	if b.js != nil {
+		durable := fmt.Sprintf("%s_%s", queueGroup, name)
+		return b.js.QueueSubscribe(subject, queueGroup, handler, nats.Durable(durable))
+	}
+	return b.conn.QueueSubscribe(subject, queueGroup, handler)
+}
+
+func (b *Backend) onBroadcast(msg *nats.Msg) {
+	b.deliver(KindBroadcast, "", "", msg.Data)
+}
+
+func (b *Backend) onRoomcast(msg *nats.Msg) {
+	env, ok := decodeEnvelope(msg.Data)
+	if !ok {
+		return
+	}
+	b.deliver(KindRoomcast, env.Room, "", env.Data)
+}
+
+func (b *Backend) onSocketcast(msg *nats.Msg) {
+	env, ok := decodeEnvelope(msg.Data)
+	if !ok {
+		return
+	}
+	b.deliver(KindSocketcast, "", env.SocketID, env.Data)
+}
+
+// Roomcast publishes msg to every node subscribed to room, wherever in
+// the cluster they are.
+func (b *Backend) Roomcast(room string, msg []byte) error {
+	return b.publish(fmt.Sprintf(roomSubjectFmt, b.cluster, room), envelope{Room: room, Data: msg})
+}
+
+// Broadcast publishes msg to every node in the cluster.
+func (b *Backend) Broadcast(msg []byte) error {
+	return b.publishRaw(fmt.Sprintf(broadcastSubjectFmt, b.cluster), msg)
+}
+
+// Socketcast publishes msg to whichever node socketID is connected to.
+func (b *Backend) Socketcast(socketID string, msg []byte) error {
+	return b.publish(fmt.Sprintf(socketSubjectFmt, b.cluster, socketID), envelope{SocketID: socketID, Data: msg})
+}
+
+func (b *Backend) publish(subject string, env envelope) error {
+	data, err := encodeEnvelope(env)
+	if err != nil {
+		return err
+	}
+	return b.publishRaw(subject, data)
+}
+
+func (b *Backend) publishRaw(subject string, data []byte) error {
+	if b.js != nil {
+		_, err := b.js.Publish(subject, data)
+		return err
+	}
+	return b.conn.Publish(subject, data)
+}
+
+// Close unsubscribes from every subject and closes the NATS connection.
+func (b *Backend) Close() error {
+	for _, sub := range b.subs {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Err.Println("ssnats: unsubscribe error:", err)
+		}
+	}
+
+	b.conn.Close()
+
+	return nil
+}
+
+func randomNodeID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "ssnats-node"
+	}
+	return "ssnats-" + hex.EncodeToString(buf)
+}
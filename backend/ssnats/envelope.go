@@ -0,0 +1,19 @@
+package ssnats
+
+import "encoding/json"
+
+// encodeEnvelope/decodeEnvelope wrap a roomcast/socketcast payload with
+// the routing metadata (room or socket id) ssnats needs once a message
+// arrives on a wildcard subject; the broadcast subject carries the raw
+// payload directly since there's no extra routing info to attach.
+func encodeEnvelope(env envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+func decodeEnvelope(data []byte) (envelope, bool) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return envelope{}, false
+	}
+	return env, true
+}
@@ -70,15 +70,18 @@ func main() {
 
 	peers := strings.Split(*peerList, ",")
 
-	var b ss.MultihomeBackend
-
+	var grpcBackend *ssgrpc.Backend
 	if *insecure {
-		b = ssgrpc.NewInsecureBackend(*grpcHostPort, peers)
+		grpcBackend = ssgrpc.NewInsecureBackend(*grpcHostPort, peers)
 	} else {
-		b = ssgrpc.NewBackend(*key, *cert, *grpcHostPort, []byte(*sharedKey), peers)
+		grpcBackend = ssgrpc.NewBackend(*key, *cert, *grpcHostPort, []byte(*sharedKey), peers)
 	}
 
-	s.SetMultihomeBackend(b)
+	s.SetMultihomeBackend(grpcBackend)
+	// SetDeliverer must be called right after SetMultihomeBackend, or
+	// messages propagated from peers are logged and dropped instead of
+	// reaching sockets connected to this instance.
+	grpcBackend.SetDeliverer(s)
 
 	c := make(chan bool)
 	s.EnableSignalShutdown(c)